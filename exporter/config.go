@@ -10,6 +10,9 @@ import (
 	"go.yaml.in/yaml/v2"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/ISE-TU-Berlin/shelly-exporter/exporter/kafka"
+	"github.com/ISE-TU-Berlin/shelly-exporter/exporter/mqtt"
 )
 
 type Config struct {
@@ -21,6 +24,18 @@ type Config struct {
 	Devices []Device `yaml:"devices"`
 
 	LogLevel string `yaml:"log_level"`
+
+	// MQTT is left nil to preserve current behavior; set it to publish
+	// observations to a broker in addition to /metrics.
+	MQTT *mqtt.Config `yaml:"mqtt"`
+
+	// Kafka is left nil to preserve current behavior; set it to publish
+	// observations to a topic in addition to /metrics.
+	Kafka *kafka.Config `yaml:"kafka"`
+
+	// SubscriptionListPath is where the webhook subscription list is
+	// persisted. Defaults to "subscriptions.json" next to the config file.
+	SubscriptionListPath string `yaml:"subscription_list_path"`
 }
 
 func (c *Config) FillDefaults() {
@@ -39,6 +54,14 @@ func (c *Config) FillDefaults() {
 	if c.LogLevel == "" {
 		c.LogLevel = "error"
 	}
+
+	if c.MQTT != nil {
+		c.MQTT.FillDefaults()
+	}
+
+	if c.Kafka != nil {
+		c.Kafka.FillDefaults()
+	}
 }
 
 func LoadShellyExporter() *ShellyExporter {
@@ -68,6 +91,10 @@ func LoadShellyExporter() *ShellyExporter {
 
 	cnf.FillDefaults()
 
+	if cnf.SubscriptionListPath == "" {
+		cnf.SubscriptionListPath = defaultSubscriptionListPath(config)
+	}
+
 	level, err := log.ParseLevel(cnf.LogLevel)
 	if err != nil {
 		fmt.Printf("Invalid log level %s, using debug\n", cnf.LogLevel)
@@ -77,19 +104,21 @@ func LoadShellyExporter() *ShellyExporter {
 
 	iface, err := net.InterfaceByName(cnf.Interface)
 	if err != nil {
-		log.Fatalf("interface %s not found: %v", cnf.Interface, err)
+		fmt.Printf("Interface %s not found: %v\n", cnf.Interface, err)
+		os.Exit(-1)
 	}
 
 	// create resolver that only uses the specified interface
 	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces([]net.Interface{*iface}))
 	if err != nil {
-		log.Fatalf("failed to create resolver: %v", err)
+		fmt.Printf("Failed to create resolver: %v\n", err)
+		os.Exit(-1)
 	}
 
-	shellyExporter := NewShellyExporter(resolver, cnf)
+	shellyExporter := NewShellyExporter(resolver, cnf, nil)
 
 	if shellyExporter.MetricsEndpoint == "" {
-		log.Error("Metrics endpoint not set, exiting")
+		shellyExporter.errorf(nil, "Metrics endpoint not set, exiting")
 		os.Exit(1)
 	}
 
@@ -101,13 +130,56 @@ func LoadShellyExporter() *ShellyExporter {
 	return &shellyExporter
 }
 
-func NewShellyExporter(resolver *zeroconf.Resolver, cnf Config) ShellyExporter {
-	return ShellyExporter{
+// NewShellyExporter builds a ShellyExporter. logger may be nil, in which
+// case the default logrus-backed Logger is used.
+func NewShellyExporter(resolver *zeroconf.Resolver, cnf Config, logger Logger) ShellyExporter {
+	if logger == nil {
+		logger = logrusLogger{}
+	}
+
+	se := ShellyExporter{
 		resolver:        resolver,
 		devices:         DeviceSet{Devices: make([]Device, 0)},
 		SamplingFreq:    cnf.SamplingFreq,
 		DiscoveryFreq:   cnf.DiscoveryFreq,
 		observations:    map[string]*PowerStateResponse{},
+		genericMetrics:  map[string]*genericReading{},
 		MetricsEndpoint: cnf.MetricsEndpoint,
+
+		subscriptionListPath: cnf.SubscriptionListPath,
+		events:               make(chan Event, subscriptionEventQueueSize),
+		consecutiveFailures:  map[string]int{},
+		lastAPower:           map[string]float64{},
+
+		logger: logger,
+	}
+
+	if cnf.SubscriptionListPath != "" {
+		subs, err := loadSubscriptions(cnf.SubscriptionListPath)
+		if err != nil {
+			se.errorf(nil, "Could not load subscriptions from %s: %v, starting with an empty list", cnf.SubscriptionListPath, err)
+		} else {
+			se.subscriptions = subs
+		}
 	}
+
+	if cnf.MQTT != nil {
+		publisher, err := mqtt.NewPublisher(*cnf.MQTT)
+		if err != nil {
+			se.errorf(nil, "Could not start mqtt publisher: %v, continuing without it", err)
+		} else {
+			se.mqttPublisher = publisher
+		}
+	}
+
+	if cnf.Kafka != nil {
+		producer, err := kafka.NewProducer(*cnf.Kafka)
+		if err != nil {
+			se.errorf(nil, "Could not start kafka producer: %v, continuing without it", err)
+		} else {
+			se.kafkaProducer = producer
+		}
+	}
+
+	return se
 }
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultRPCEndpoints is used for any device that doesn't configure its own
+// set of RPC endpoints to poll.
+var defaultRPCEndpoints = []string{"Switch.GetStatus"}
+
+// endpoints returns the RPC endpoints to poll for device, falling back to
+// defaultRPCEndpoints when none are configured.
+func (d Device) endpoints() []string {
+	if len(d.Endpoints) == 0 {
+		return defaultRPCEndpoints
+	}
+	return d.Endpoints
+}
+
+// genericReading holds the flattened numeric fields discovered across all of
+// a device's polled RPC endpoints, keyed by dotted-path-turned-metric-name.
+// The map itself doubles as the discovered-field cache: field names that
+// stop appearing in a device's responses simply age out on the next tick.
+type genericReading struct {
+	Name    string
+	Metrics map[string]float64
+}
+
+// getRPCStatus calls an arbitrary Gen2/Gen3 Shelly RPC endpoint (e.g.
+// "Sys.GetStatus") and returns its JSON response as a generic map.
+func (se *ShellyExporter) getRPCStatus(device Device, endpoint string) (map[string]interface{}, error) {
+	url := fmt.Sprintf("http://%s:%d/rpc/%s?id=0", device.IP, device.Port, endpoint)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// collectGenericMetrics polls every RPC endpoint configured for device,
+// flattens each response and stores the result for exposition by
+// GetObservations.
+func (se *ShellyExporter) collectGenericMetrics(device Device) {
+	metrics := make(map[string]float64)
+	succeeded := false
+	for _, endpoint := range device.endpoints() {
+		status, err := se.getRPCStatus(device, endpoint)
+		if err != nil {
+			se.errorf(instanceFields(device.Instance), "Error calling %s for device %s: %v", endpoint, device.Instance, err)
+			continue
+		}
+		succeeded = true
+		parseMap(status, nil, metrics)
+	}
+
+	if !succeeded {
+		// Every endpoint failed this tick; keep exposing the last-known
+		// readings instead of wiping them out.
+		return
+	}
+
+	se.observationMutex.Lock()
+	se.genericMetrics[device.Instance] = &genericReading{Name: device.Name, Metrics: metrics}
+	se.observationMutex.Unlock()
+}
+
+// parseMap recursively descends amap, flattening nested objects into
+// dotted paths (rendered with "_" as separator) and recording every numeric
+// leaf into out. Booleans are recorded as 0/1 gauges; everything else
+// (strings, arrays, null) is ignored.
+func parseMap(amap map[string]interface{}, path []string, out map[string]float64) {
+	for key, value := range amap {
+		fieldPath := append(append([]string{}, path...), key)
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			parseMap(v, fieldPath, out)
+		case float64:
+			out[metricName(fieldPath)] = v
+		case bool:
+			if v {
+				out[metricName(fieldPath)] = 1
+			} else {
+				out[metricName(fieldPath)] = 0
+			}
+		}
+	}
+}
+
+// metricName turns a dotted field path into a valid Prometheus metric name
+// suffix.
+func metricName(path []string) string {
+	name := strings.Join(path, "_")
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
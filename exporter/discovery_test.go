@@ -0,0 +1,44 @@
+package exporter
+
+import "testing"
+
+func TestParseMapFlattensNestedFields(t *testing.T) {
+	amap := map[string]interface{}{
+		"apower": 12.5,
+		"aenergy": map[string]interface{}{
+			"total": 42.0,
+		},
+		"output": true,
+		"source": "init",
+	}
+
+	out := make(map[string]float64)
+	parseMap(amap, nil, out)
+
+	if out["apower"] != 12.5 {
+		t.Fatalf("expected apower 12.5, got %v", out["apower"])
+	}
+	if out["aenergy_total"] != 42.0 {
+		t.Fatalf("expected aenergy_total 42.0, got %v", out["aenergy_total"])
+	}
+	if out["output"] != 1 {
+		t.Fatalf("expected output 1, got %v", out["output"])
+	}
+	if _, ok := out["source"]; ok {
+		t.Fatalf("expected string field source to be ignored, got %v", out["source"])
+	}
+}
+
+func TestDeviceEndpointsDefault(t *testing.T) {
+	d := Device{Instance: "dev-1"}
+	eps := d.endpoints()
+	if len(eps) != 1 || eps[0] != "Switch.GetStatus" {
+		t.Fatalf("expected default endpoint [Switch.GetStatus], got %v", eps)
+	}
+
+	d.Endpoints = []string{"Switch.GetStatus", "Sys.GetStatus"}
+	eps = d.endpoints()
+	if len(eps) != 2 {
+		t.Fatalf("expected configured endpoints to be used, got %v", eps)
+	}
+}
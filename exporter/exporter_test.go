@@ -44,7 +44,7 @@ func TestDeviceSetAppendAndGet(t *testing.T) {
 
 func TestAddDeviceAndDeviceCount(t *testing.T) {
 	cfg := Config{MetricsEndpoint: ":0"}
-	se := NewShellyExporter(nil, cfg)
+	se := NewShellyExporter(nil, cfg, NopLogger{})
 
 	// adding device without IP should be ignored
 	se.AddDevice(Device{Instance: "noip"})
@@ -74,7 +74,7 @@ func TestPowerStateResponseJSONDecode(t *testing.T) {
 
 func TestListDevices(t *testing.T) {
 	cfg := Config{MetricsEndpoint: ":0"}
-	se := NewShellyExporter(nil, cfg)
+	se := NewShellyExporter(nil, cfg, NopLogger{})
 
 	se.AddDevice(Device{Instance: "dev-1", IP: "192.0.2.1", Port: 80, Name: "test-device"})
 
@@ -106,7 +106,7 @@ func TestListDevices(t *testing.T) {
 
 func TestAddDeviceHandler(t *testing.T) {
 	cfg := Config{MetricsEndpoint: ":0"}
-	se := NewShellyExporter(nil, cfg)
+	se := NewShellyExporter(nil, cfg, NopLogger{})
 
 	deviceJSON := `{"instance":"dev-2","ip":"192.168.2.149","port":80,"name":"new-device"}`
 	req, err := http.NewRequest("POST", "/devices", nil)
@@ -129,7 +129,7 @@ func TestAddDeviceHandler(t *testing.T) {
 
 func TestUpdateDeviceHandler(t *testing.T) {
 	cfg := Config{MetricsEndpoint: ":0"}
-	se := NewShellyExporter(nil, cfg)
+	se := NewShellyExporter(nil, cfg, NopLogger{})
 
 	se.AddDevice(Device{Instance: "dev-3", IP: "192.0.2.3", Port: 80})
 	updateJSON := `{"instance":"dev-3","ip":"192.0.2.3","port":80, "name":"updated-device"}`
@@ -155,3 +155,207 @@ func TestUpdateDeviceHandler(t *testing.T) {
 		t.Fatalf("expected updated name 'updated-device', got %q", devices[0].Name)
 	}
 }
+
+func TestDeleteDeviceHandler(t *testing.T) {
+	cfg := Config{MetricsEndpoint: ":0"}
+	se := NewShellyExporter(nil, cfg, NopLogger{})
+
+	se.AddDevice(Device{Instance: "dev-4", IP: "192.0.2.4", Port: 80})
+
+	req, err := http.NewRequest("DELETE", "/devices/dev-4", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.SetPathValue("instance", "dev-4")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(se.DeleteDevice)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v, want %v", status, http.StatusOK)
+	}
+	if se.DeviceCount() != 0 {
+		t.Fatalf("expected 0 devices after deletion, got %d", se.DeviceCount())
+	}
+}
+
+func TestSendDeviceListHandler(t *testing.T) {
+	cfg := Config{MetricsEndpoint: ":0"}
+	se := NewShellyExporter(nil, cfg, NopLogger{})
+	se.AddDevice(Device{Instance: "dev-5", IP: "192.0.2.5", Port: 80})
+
+	bulkJSON := `[{"instance":"dev-5","ip":"192.0.2.5","port":80},{"instance":"dev-6","ip":"192.0.2.6","port":80},{"instance":"dev-7"}]`
+	req, err := http.NewRequest("POST", "/devices/bulk", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Body = io.NopCloser(strings.NewReader(bulkJSON))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(se.SendDeviceList)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v, want %v", status, http.StatusOK)
+	}
+
+	var results []Result
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Status != "duplicate" || results[1].Status != "added" || results[2].Status != "invalid" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if se.DeviceCount() != 2 {
+		t.Fatalf("expected 2 devices after bulk add, got %d", se.DeviceCount())
+	}
+}
+
+func TestDeleteDeviceListHandler(t *testing.T) {
+	cfg := Config{MetricsEndpoint: ":0"}
+	se := NewShellyExporter(nil, cfg, NopLogger{})
+	se.AddDevice(Device{Instance: "dev-8", IP: "192.0.2.8", Port: 80})
+
+	deleteJSON := `["dev-8","dev-unknown"]`
+	req, err := http.NewRequest("DELETE", "/devices/bulk", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Body = io.NopCloser(strings.NewReader(deleteJSON))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(se.DeleteDeviceList)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v, want %v", status, http.StatusOK)
+	}
+
+	var results []Result
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(results) != 2 || results[0].Status != "removed" || results[1].Status != "not_found" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if se.DeviceCount() != 0 {
+		t.Fatalf("expected 0 devices after bulk delete, got %d", se.DeviceCount())
+	}
+}
+
+func TestCreateAndListSubscriptions(t *testing.T) {
+	cfg := Config{MetricsEndpoint: ":0"}
+	se := NewShellyExporter(nil, cfg, NopLogger{})
+
+	subJSON := `{"destination":"http://example.invalid/hook","event_types":["DeviceDiscovered"]}`
+	req, err := http.NewRequest("POST", "/subscriptions", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Body = io.NopCloser(strings.NewReader(subJSON))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(se.CreateSubscription).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v, want %v", status, http.StatusCreated)
+	}
+
+	var created Subscription
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if created.ID == "" {
+		t.Fatalf("expected a generated subscription ID")
+	}
+
+	listReq, err := http.NewRequest("GET", "/subscriptions", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	listRR := httptest.NewRecorder()
+	http.HandlerFunc(se.ListSubscriptions).ServeHTTP(listRR, listReq)
+
+	var subs []Subscription
+	if err := json.Unmarshal(listRR.Body.Bytes(), &subs); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(subs) != 1 || subs[0].ID != created.ID {
+		t.Fatalf("unexpected subscriptions: %+v", subs)
+	}
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	cfg := Config{MetricsEndpoint: ":0"}
+	se := NewShellyExporter(nil, cfg, NopLogger{})
+	se.subscriptions = append(se.subscriptions, Subscription{ID: "sub-1", Destination: "http://example.invalid/hook", EventTypes: []string{"DeviceRemoved"}})
+
+	req, err := http.NewRequest("DELETE", "/subscriptions/sub-1", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.SetPathValue("id", "sub-1")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(se.DeleteSubscription).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v, want %v", status, http.StatusOK)
+	}
+	if len(se.subscriptions) != 0 {
+		t.Fatalf("expected subscription to be removed, got %+v", se.subscriptions)
+	}
+}
+
+// capturingLogger records every line passed to it so tests can assert on the
+// structured fields a call site attached.
+type capturingLogger struct {
+	verbose []capturedLine
+	errors  []capturedLine
+}
+
+type capturedLine struct {
+	msg    string
+	fields Fields
+}
+
+func (c *capturingLogger) Verbosef(msg string, fields Fields) {
+	c.verbose = append(c.verbose, capturedLine{msg, fields})
+}
+
+func (c *capturingLogger) Errorf(msg string, fields Fields) {
+	c.errors = append(c.errors, capturedLine{msg, fields})
+}
+
+func (c *capturingLogger) Level() string { return "verbose" }
+
+func TestAddDeviceLogsInstanceField(t *testing.T) {
+	cfg := Config{MetricsEndpoint: ":0"}
+	logger := &capturingLogger{}
+	se := NewShellyExporter(nil, cfg, logger)
+
+	// a device without an IP is rejected, which should produce a device-scoped
+	// warning carrying the "instance" field.
+	se.AddDevice(Device{Instance: "dev-9"})
+
+	if len(logger.verbose) == 0 {
+		t.Fatalf("expected at least one log line from AddDevice, got none")
+	}
+	last := logger.verbose[len(logger.verbose)-1]
+	if last.fields["instance"] != "dev-9" {
+		t.Fatalf("expected instance field %q, got %+v", "dev-9", last.fields)
+	}
+}
+
+func TestSubscriptionMatchesPowerThreshold(t *testing.T) {
+	sub := Subscription{ID: "sub-2", Destination: "http://example.invalid/hook", EventTypes: []string{EventPowerThresholdCrossed}, ThresholdWatts: 100}
+
+	belowToAbove := Event{Type: EventPowerThresholdCrossed, Instance: "plug-1", PrevAPower: 50, APower: 150}
+	if !sub.matches(belowToAbove) {
+		t.Fatalf("expected a crossing from below to above threshold to match")
+	}
+
+	staysAbove := Event{Type: EventPowerThresholdCrossed, Instance: "plug-1", PrevAPower: 150, APower: 200}
+	if sub.matches(staysAbove) {
+		t.Fatalf("expected no match when the threshold isn't crossed")
+	}
+}
@@ -0,0 +1,223 @@
+// Package kafka publishes Shelly power observations onto a Kafka topic via
+// sarama.AsyncProducer, so downstream systems can consume a push-based event
+// stream instead of scraping /metrics.
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// TLSConfig configures TLS for the broker connection.
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	CAFile             string `yaml:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Config configures the optional Kafka sink. It is embedded in the
+// exporter's top level Config and left zero-value (nil) when Kafka
+// publishing is disabled.
+type Config struct {
+	Brokers      []string   `yaml:"brokers"`
+	Topic        string     `yaml:"topic"`
+	TLS          *TLSConfig `yaml:"tls"`
+	Compression  string     `yaml:"compression"`   // "none", "gzip", "snappy", "lz4", "zstd"
+	RequiredAcks string     `yaml:"required_acks"` // "none", "local", "all"
+}
+
+// FillDefaults fills in sane defaults for fields the operator left unset.
+func (c *Config) FillDefaults() {
+	if c.Topic == "" {
+		c.Topic = "shelly.power"
+	}
+	if c.Compression == "" {
+		c.Compression = "none"
+	}
+	if c.RequiredAcks == "" {
+		c.RequiredAcks = "local"
+	}
+}
+
+// Record is the JSON payload published for every observation.
+type Record struct {
+	Instance  string    `json:"instance"`
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	APower    float64   `json:"apower"`
+	Voltage   float64   `json:"voltage"`
+	Freq      float64   `json:"freq"`
+	Current   float64   `json:"current"`
+}
+
+// Producer publishes Records to a Kafka topic asynchronously, tracking how
+// many were acknowledged versus failed.
+type Producer struct {
+	producer sarama.AsyncProducer
+	topic    string
+	drainWg  sync.WaitGroup
+
+	published uint64
+	failed    uint64
+}
+
+// NewProducer connects a Producer using sarama's async client.
+func NewProducer(cfg Config) (*Producer, error) {
+	cfg.FillDefaults()
+
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Producer.Return.Errors = true
+
+	acks, err := parseRequiredAcks(cfg.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+	saramaCfg.Producer.RequiredAcks = acks
+
+	compression, err := parseCompression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	saramaCfg.Producer.Compression = compression
+
+	if cfg.TLS != nil && cfg.TLS.Enabled {
+		tlsConfig, err := buildTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("building kafka tls config: %v", err)
+		}
+		saramaCfg.Net.TLS.Enable = true
+		saramaCfg.Net.TLS.Config = tlsConfig
+	}
+
+	producer, err := sarama.NewAsyncProducer(cfg.Brokers, saramaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to kafka brokers %v: %v", cfg.Brokers, err)
+	}
+
+	return newProducer(cfg.Topic, producer), nil
+}
+
+// newProducer wraps an already-constructed sarama.AsyncProducer, draining
+// its Successes/Errors channels. Used directly in tests with a mock
+// producer.
+func newProducer(topic string, producer sarama.AsyncProducer) *Producer {
+	p := &Producer{producer: producer, topic: topic}
+
+	p.drainWg.Add(2)
+	go func() {
+		defer p.drainWg.Done()
+		for range producer.Successes() {
+			atomic.AddUint64(&p.published, 1)
+		}
+	}()
+	go func() {
+		defer p.drainWg.Done()
+		for range producer.Errors() {
+			atomic.AddUint64(&p.failed, 1)
+		}
+	}()
+
+	return p
+}
+
+// Publish marshals record as JSON and enqueues it on the producer's input
+// channel.
+func (p *Producer) Publish(record Record) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshalling kafka record for %s: %v", record.Instance, err)
+	}
+
+	p.producer.Input() <- &sarama.ProducerMessage{
+		Topic: p.topic,
+		Value: sarama.ByteEncoder(payload),
+	}
+	return nil
+}
+
+// Published returns the number of messages acknowledged by the brokers so
+// far.
+func (p *Producer) Published() uint64 {
+	return atomic.LoadUint64(&p.published)
+}
+
+// Failed returns the number of messages that failed to publish so far.
+func (p *Producer) Failed() uint64 {
+	return atomic.LoadUint64(&p.failed)
+}
+
+// Close shuts the underlying producer down, flushing any in-flight messages,
+// and waits for the Successes/Errors drain goroutines to finish consuming
+// them so Published/Failed reflect the final counts.
+func (p *Producer) Close() error {
+	err := p.producer.Close()
+	p.drainWg.Wait()
+	return err
+}
+
+func parseRequiredAcks(acks string) (sarama.RequiredAcks, error) {
+	switch acks {
+	case "none":
+		return sarama.NoResponse, nil
+	case "local":
+		return sarama.WaitForLocal, nil
+	case "all":
+		return sarama.WaitForAll, nil
+	default:
+		return 0, fmt.Errorf("unknown required_acks %q", acks)
+	}
+}
+
+func parseCompression(compression string) (sarama.CompressionCodec, error) {
+	switch compression {
+	case "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+func buildTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		caCert, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
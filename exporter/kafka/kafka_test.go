@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+func TestPublishSuccessIncrementsPublished(t *testing.T) {
+	// mirror NewProducer, which enables Return.Successes; the mock only
+	// reports successes when it is set.
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	mockProducer := mocks.NewAsyncProducer(t, cfg)
+	mockProducer.ExpectInputAndSucceed()
+
+	p := newProducer("shelly.power", mockProducer)
+
+	err := p.Publish(Record{Instance: "plug-1", Name: "kitchen", Timestamp: time.Unix(0, 0).UTC(), APower: 12.5})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if p.Published() != 1 {
+		t.Fatalf("expected Published() == 1, got %d", p.Published())
+	}
+	if p.Failed() != 0 {
+		t.Fatalf("expected Failed() == 0, got %d", p.Failed())
+	}
+}
+
+func TestPublishErrorIncrementsFailed(t *testing.T) {
+	mockProducer := mocks.NewAsyncProducer(t, nil)
+	mockProducer.ExpectInputAndFail(errors.New("broker unavailable"))
+
+	p := newProducer("shelly.power", mockProducer)
+
+	if err := p.Publish(Record{Instance: "plug-2"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if p.Failed() != 1 {
+		t.Fatalf("expected Failed() == 1, got %d", p.Failed())
+	}
+}
+
+func TestParseRequiredAcksAndCompression(t *testing.T) {
+	if _, err := parseRequiredAcks("bogus"); err == nil {
+		t.Fatalf("expected error for unknown required_acks")
+	}
+	if _, err := parseCompression("bogus"); err == nil {
+		t.Fatalf("expected error for unknown compression")
+	}
+	if _, err := parseRequiredAcks("all"); err != nil {
+		t.Fatalf("unexpected error for required_acks=all: %v", err)
+	}
+	if _, err := parseCompression("gzip"); err != nil {
+		t.Fatalf("unexpected error for compression=gzip: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Fields carries structured key/value context for a single log line. Every
+// device-scoped log line should set "instance" so entries can be correlated
+// across devices regardless of which Logger implementation is in use.
+type Fields map[string]interface{}
+
+// Logger is the pluggable logging sink used throughout the exporter. It
+// follows wireguard-go's device logger split: a single "verbose" level for
+// everything that isn't an error, plus Errorf for failures. Inject a JSON
+// logger, a silent logger for tests, or rely on the default logrus adapter.
+type Logger interface {
+	Verbosef(msg string, fields Fields)
+	Errorf(msg string, fields Fields)
+	Level() string
+}
+
+// logrusLogger is the default Logger, backed by the package-level logrus
+// instance so the log_level setting still applies. Verbosef lines are logged
+// at debug level and Errorf lines at error level, so lines that were
+// previously logged at info or warn level now only show at debug level.
+type logrusLogger struct{}
+
+func (logrusLogger) Verbosef(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Debug(msg)
+}
+
+func (logrusLogger) Errorf(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Error(msg)
+}
+
+func (logrusLogger) Level() string {
+	return log.GetLevel().String()
+}
+
+// NopLogger discards every log line. Useful for tests that don't care about
+// log output.
+type NopLogger struct{}
+
+func (NopLogger) Verbosef(string, Fields) {}
+func (NopLogger) Errorf(string, Fields)   {}
+func (NopLogger) Level() string           { return "none" }
+
+// instanceFields is shorthand for the Fields every device-scoped log line
+// must carry.
+func instanceFields(instance string) Fields {
+	return Fields{"instance": instance}
+}
+
+func (se *ShellyExporter) debugf(fields Fields, format string, args ...interface{}) {
+	se.logger.Verbosef(fmt.Sprintf(format, args...), fields)
+}
+
+func (se *ShellyExporter) infof(fields Fields, format string, args ...interface{}) {
+	se.logger.Verbosef(fmt.Sprintf(format, args...), fields)
+}
+
+func (se *ShellyExporter) warnf(fields Fields, format string, args ...interface{}) {
+	se.logger.Verbosef(fmt.Sprintf(format, args...), fields)
+}
+
+func (se *ShellyExporter) errorf(fields Fields, format string, args ...interface{}) {
+	se.logger.Errorf(fmt.Sprintf(format, args...), fields)
+}
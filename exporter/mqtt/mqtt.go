@@ -0,0 +1,205 @@
+// Package mqtt publishes Shelly power observations to an MQTT broker and
+// relays Switch.Set commands back to the exporter, so the exporter can feed
+// home-automation/HomeKit bridges in addition to its Prometheus endpoint.
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Config configures the optional MQTT sink. It is embedded in the exporter's
+// top level Config and left zero-value (nil) when MQTT publishing is
+// disabled.
+type Config struct {
+	BrokerURL       string        `yaml:"broker_url"`
+	ClientID        string        `yaml:"client_id"`
+	Username        string        `yaml:"username"`
+	Password        string        `yaml:"password"`
+	TopicTemplate   string        `yaml:"topic_template"`
+	QoS             byte          `yaml:"qos"`
+	Retain          bool          `yaml:"retain"`
+	PublishInterval time.Duration `yaml:"publish_interval"`
+}
+
+// FillDefaults fills in sane defaults for fields the operator left unset.
+func (c *Config) FillDefaults() {
+	if c.ClientID == "" {
+		c.ClientID = "shelly-exporter"
+	}
+	if c.TopicTemplate == "" {
+		c.TopicTemplate = "shelly/{instance}/state"
+	}
+	if c.PublishInterval == 0 {
+		c.PublishInterval = 30 * time.Second
+	}
+}
+
+// Observation is the subset of a power-state reading that gets published to
+// MQTT. It is defined here rather than imported from the exporter package to
+// keep this package dependency-free of the exporter library.
+type Observation struct {
+	Instance  string    `json:"instance"`
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	APower    float64   `json:"apower"`
+	Voltage   float64   `json:"voltage"`
+	Freq      float64   `json:"freq"`
+	Current   float64   `json:"current"`
+}
+
+// CommandHandler is invoked when a command is received on a device's "set"
+// topic. on reports the requested switch state.
+type CommandHandler func(instance string, on bool) error
+
+// Client is the subset of an MQTT client the Publisher needs. It exists so
+// tests can supply a mock broker instead of a real eclipse-paho connection.
+type Client interface {
+	Connect() error
+	Publish(topic string, qos byte, retain bool, payload []byte) error
+	Subscribe(topic string, qos byte, callback func(topic string, payload []byte)) error
+	Disconnect()
+}
+
+// Publisher publishes PowerStateResponse observations to an MQTT broker and
+// dispatches inbound "set" commands to a CommandHandler.
+type Publisher struct {
+	client Client
+	cfg    Config
+}
+
+// NewPublisher connects a Publisher using the eclipse-paho client. Use
+// NewPublisherWithClient in tests to inject a mock broker.
+func NewPublisher(cfg Config) (*Publisher, error) {
+	cfg.FillDefaults()
+	return NewPublisherWithClient(cfg, newPahoClient(cfg))
+}
+
+// NewPublisherWithClient builds a Publisher around an already-constructed
+// Client, connecting it immediately.
+func NewPublisherWithClient(cfg Config, client Client) (*Publisher, error) {
+	cfg.FillDefaults()
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("connecting to mqtt broker %s: %v", cfg.BrokerURL, err)
+	}
+	return &Publisher{client: client, cfg: cfg}, nil
+}
+
+// stateTopic renders the configured topic template for instance.
+func (p *Publisher) stateTopic(instance string) string {
+	return strings.ReplaceAll(p.cfg.TopicTemplate, "{instance}", instance)
+}
+
+// fieldTopic returns the per-field topic alongside the aggregate state
+// topic, e.g. ".../apower" next to ".../state".
+func (p *Publisher) fieldTopic(instance, field string) string {
+	base := p.stateTopic(instance)
+	if idx := strings.LastIndex(base, "/"); idx != -1 {
+		return base[:idx] + "/" + field
+	}
+	return field
+}
+
+// commandTopic returns the topic a device's Switch.Set commands are
+// delivered on.
+func (p *Publisher) commandTopic(instance string) string {
+	base := p.stateTopic(instance)
+	if idx := strings.LastIndex(base, "/"); idx != -1 {
+		return base[:idx] + "/set"
+	}
+	return "set"
+}
+
+// PublishObservation publishes obs as an aggregate JSON payload on the
+// instance's state topic, plus one retained-or-not message per numeric
+// field.
+func (p *Publisher) PublishObservation(obs Observation) error {
+	payload, err := json.Marshal(obs)
+	if err != nil {
+		return fmt.Errorf("marshalling observation for %s: %v", obs.Instance, err)
+	}
+	if err := p.client.Publish(p.stateTopic(obs.Instance), p.cfg.QoS, p.cfg.Retain, payload); err != nil {
+		return fmt.Errorf("publishing state for %s: %v", obs.Instance, err)
+	}
+
+	fields := map[string]float64{
+		"apower":  obs.APower,
+		"voltage": obs.Voltage,
+		"freq":    obs.Freq,
+		"current": obs.Current,
+	}
+	for field, value := range fields {
+		if err := p.client.Publish(p.fieldTopic(obs.Instance, field), p.cfg.QoS, p.cfg.Retain, []byte(fmt.Sprintf("%f", value))); err != nil {
+			return fmt.Errorf("publishing %s for %s: %v", field, obs.Instance, err)
+		}
+	}
+	return nil
+}
+
+// SubscribeCommands subscribes to instance's "set" topic and invokes handler
+// for every payload of "on" or "off" received on it.
+func (p *Publisher) SubscribeCommands(instance string, handler CommandHandler) error {
+	topic := p.commandTopic(instance)
+	return p.client.Subscribe(topic, p.cfg.QoS, func(_ string, payload []byte) {
+		command := strings.ToLower(strings.TrimSpace(string(payload)))
+		if command != "on" && command != "off" {
+			return
+		}
+		on := command == "on"
+		if err := handler(instance, on); err != nil {
+			// The caller's handler is responsible for its own error
+			// reporting; there is nothing more actionable to do here.
+			_ = err
+		}
+	})
+}
+
+// Close disconnects the underlying client.
+func (p *Publisher) Close() {
+	p.client.Disconnect()
+}
+
+// pahoClient adapts an eclipse-paho mqtt.Client to the Client interface.
+type pahoClient struct {
+	cfg    Config
+	client paho.Client
+}
+
+func newPahoClient(cfg Config) *pahoClient {
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true)
+
+	return &pahoClient{cfg: cfg, client: paho.NewClient(opts)}
+}
+
+func (c *pahoClient) Connect() error {
+	token := c.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+func (c *pahoClient) Publish(topic string, qos byte, retain bool, payload []byte) error {
+	token := c.client.Publish(topic, qos, retain, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (c *pahoClient) Subscribe(topic string, qos byte, callback func(topic string, payload []byte)) error {
+	token := c.client.Subscribe(topic, qos, func(_ paho.Client, msg paho.Message) {
+		callback(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (c *pahoClient) Disconnect() {
+	c.client.Disconnect(250)
+}
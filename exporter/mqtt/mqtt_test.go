@@ -0,0 +1,104 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// mockClient is an in-memory stand-in for an MQTT broker connection, used so
+// tests don't need a running broker.
+type mockClient struct {
+	connected bool
+	published map[string][]byte
+	subs      map[string]func(topic string, payload []byte)
+}
+
+func newMockClient() *mockClient {
+	return &mockClient{
+		published: make(map[string][]byte),
+		subs:      make(map[string]func(topic string, payload []byte)),
+	}
+}
+
+func (m *mockClient) Connect() error {
+	m.connected = true
+	return nil
+}
+
+func (m *mockClient) Publish(topic string, qos byte, retain bool, payload []byte) error {
+	m.published[topic] = payload
+	return nil
+}
+
+func (m *mockClient) Subscribe(topic string, qos byte, callback func(topic string, payload []byte)) error {
+	m.subs[topic] = callback
+	return nil
+}
+
+func (m *mockClient) Disconnect() {
+	m.connected = false
+}
+
+func (m *mockClient) deliver(topic string, payload []byte) {
+	if cb, ok := m.subs[topic]; ok {
+		cb(topic, payload)
+	}
+}
+
+func TestPublishObservation(t *testing.T) {
+	client := newMockClient()
+	pub, err := NewPublisherWithClient(Config{TopicTemplate: "shelly/{instance}/state"}, client)
+	if err != nil {
+		t.Fatalf("NewPublisherWithClient failed: %v", err)
+	}
+
+	obs := Observation{Instance: "plug-1", Name: "kitchen", Timestamp: time.Unix(0, 0).UTC(), APower: 12.5, Voltage: 230}
+	if err := pub.PublishObservation(obs); err != nil {
+		t.Fatalf("PublishObservation failed: %v", err)
+	}
+
+	statePayload, ok := client.published["shelly/plug-1/state"]
+	if !ok {
+		t.Fatalf("expected a message on shelly/plug-1/state, got %+v", client.published)
+	}
+	var got Observation
+	if err := json.Unmarshal(statePayload, &got); err != nil {
+		t.Fatalf("failed to unmarshal state payload: %v", err)
+	}
+	if got.Instance != "plug-1" || got.Name != "kitchen" {
+		t.Fatalf("unexpected state payload: %+v", got)
+	}
+
+	if _, ok := client.published["shelly/plug-1/apower"]; !ok {
+		t.Fatalf("expected a message on shelly/plug-1/apower, got %+v", client.published)
+	}
+}
+
+func TestSubscribeCommands(t *testing.T) {
+	client := newMockClient()
+	pub, err := NewPublisherWithClient(Config{TopicTemplate: "shelly/{instance}/state"}, client)
+	if err != nil {
+		t.Fatalf("NewPublisherWithClient failed: %v", err)
+	}
+
+	var gotInstance string
+	var gotOn bool
+	err = pub.SubscribeCommands("plug-1", func(instance string, on bool) error {
+		gotInstance = instance
+		gotOn = on
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SubscribeCommands failed: %v", err)
+	}
+
+	client.deliver("shelly/plug-1/set", []byte("on"))
+
+	if gotInstance != "plug-1" {
+		t.Fatalf("expected instance plug-1, got %q", gotInstance)
+	}
+	if !gotOn {
+		t.Fatalf("expected on=true")
+	}
+}
@@ -1,15 +1,19 @@
 package exporter
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/grandcat/zeroconf"
-	log "github.com/sirupsen/logrus"
+
+	"github.com/ISE-TU-Berlin/shelly-exporter/exporter/kafka"
+	"github.com/ISE-TU-Berlin/shelly-exporter/exporter/mqtt"
 )
 
 // replace the placeholder fields with concrete types at package init
@@ -19,11 +23,16 @@ func init() {
 
 func (se *ShellyExporter) AddDevice(device Device) {
 	if device.IP == "" {
-		log.Warnf("Device %s has no IP, not adding", device.Instance)
+		se.warnf(instanceFields(device.Instance), "Device %s has no IP, not adding", device.Instance)
 		return
 	}
 
+	before := se.devices.len()
 	se.devices.append(device)
+	if se.devices.len() > before {
+		se.publishEvent(Event{Type: EventDeviceDiscovered, Instance: device.Instance})
+		se.subscribeMQTTCommands(device)
+	}
 }
 
 func (se *ShellyExporter) DiscoverDevices() {
@@ -37,7 +46,7 @@ func (se *ShellyExporter) DiscoverDevices() {
 		for entry := range entries {
 			if strings.Contains(entry.Instance, "shellyplugsg3") {
 				if len(entry.AddrIPv4) == 0 {
-					log.Warnf("No IPv4 address found for device %+v", entry)
+					se.warnf(instanceFields(entry.Instance), "No IPv4 address found for device %+v", entry)
 					continue
 				}
 				device := Device{
@@ -47,7 +56,7 @@ func (se *ShellyExporter) DiscoverDevices() {
 					Port:     entry.Port,
 				}
 				se.AddDevice(device)
-				log.Debugf("Found device: %+v", device)
+				se.debugf(instanceFields(device.Instance), "Found device: %+v", device)
 			}
 
 		}
@@ -56,21 +65,27 @@ func (se *ShellyExporter) DiscoverDevices() {
 	// use the resolver created in NewShellyExporter; it is stored in the resolver field
 	err := se.resolver.Browse(ctx, "_http._tcp", "local.", entries)
 	if err != nil {
-		log.Errorf("Error during browsing: %v", err)
+		se.errorf(nil, "Error during browsing: %v", err)
 	}
 
 	<-ctx.Done()
 	if err := ctx.Err(); err != nil && err != context.DeadlineExceeded {
-		log.Errorf("Error during browsing: %v", err)
+		se.errorf(nil, "Error during browsing: %v", err)
 	}
 	after := se.devices.len()
-	log.Debugf("Discovered %d new devices, total %d devices", after-before, after)
+	se.debugf(nil, "Discovered %d new devices, total %d devices", after-before, after)
 }
 
 func (se *ShellyExporter) tick() {
+	defer se.tickWg.Done()
 	lastTick := time.Now()
 	// ensure observation mutex is a real mutex
-	for range se.ticker.C {
+	for {
+		select {
+		case <-se.tickStop:
+			return
+		case <-se.ticker.C:
+		}
 		for _, device := range se.devices.getAll() {
 			powerState, err := se.getPowerState(device)
 			powerState.Instance = device.Instance
@@ -78,11 +93,46 @@ func (se *ShellyExporter) tick() {
 			se.observationMutex.Lock()
 			se.observations[device.Instance] = &powerState
 			se.observationMutex.Unlock()
+			se.recordFailure(device.Instance, err != nil)
 			if err != nil {
-				log.Errorf("Error getting power state for device %s: %v", device.Instance, err)
+				se.errorf(instanceFields(device.Instance), "Error getting power state for device %s: %v", device.Instance, err)
 				continue
 			}
-			log.Debugf("Device %s power state: %+v", device.Instance, powerState)
+			se.debugf(instanceFields(device.Instance), "Device %s power state: %+v", device.Instance, powerState)
+
+			se.checkPowerThreshold(device.Instance, powerState.APower)
+
+			if se.mqttPublisher != nil {
+				obs := mqtt.Observation{
+					Instance:  powerState.Instance,
+					Name:      powerState.Name,
+					Timestamp: powerState.Timestamp,
+					APower:    powerState.APower,
+					Voltage:   powerState.Voltage,
+					Freq:      powerState.Freq,
+					Current:   powerState.Current,
+				}
+				if err := se.mqttPublisher.PublishObservation(obs); err != nil {
+					se.errorf(instanceFields(device.Instance), "Error publishing mqtt observation for device %s: %v", device.Instance, err)
+				}
+			}
+
+			se.collectGenericMetrics(device)
+
+			if se.kafkaProducer != nil {
+				record := kafka.Record{
+					Instance:  powerState.Instance,
+					Name:      powerState.Name,
+					Timestamp: powerState.Timestamp,
+					APower:    powerState.APower,
+					Voltage:   powerState.Voltage,
+					Freq:      powerState.Freq,
+					Current:   powerState.Current,
+				}
+				if err := se.kafkaProducer.Publish(record); err != nil {
+					se.errorf(instanceFields(device.Instance), "Error publishing kafka record for device %s: %v", device.Instance, err)
+				}
+			}
 		}
 		if time.Since(lastTick) > se.DiscoveryFreq {
 			se.DiscoverDevices()
@@ -101,6 +151,17 @@ func (se *ShellyExporter) GetObservations(w http.ResponseWriter, r *http.Request
 		fmt.Fprintf(w, "shelly_current_amps{instance=\"%s\",node=\"%s\"} %f\n", instance, obs.Name, obs.Current)
 		fmt.Fprintf(w, "shelly_frequency_hz{instance=\"%s\",node=\"%s\"} %f\n", instance, obs.Name, obs.Freq)
 	}
+
+	for instance, reading := range se.genericMetrics {
+		for field, value := range reading.Metrics {
+			fmt.Fprintf(w, "shelly_%s{instance=\"%s\",node=\"%s\"} %f\n", field, instance, reading.Name, value)
+		}
+	}
+
+	if se.kafkaProducer != nil {
+		fmt.Fprintf(w, "shelly_kafka_published_total %d\n", se.kafkaProducer.Published())
+		fmt.Fprintf(w, "shelly_kafka_failed_total %d\n", se.kafkaProducer.Failed())
+	}
 }
 
 func (se *ShellyExporter) validateDevice(device Device) error {
@@ -168,6 +229,120 @@ func (se *ShellyExporter) UpdateDevice(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (se *ShellyExporter) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	instance := r.PathValue("instance")
+
+	se.observationMutex.Lock()
+	defer se.observationMutex.Unlock()
+
+	if !se.devices.remove(instance) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	delete(se.observations, instance)
+	delete(se.genericMetrics, instance)
+	se.publishEvent(Event{Type: EventDeviceRemoved, Instance: instance})
+	w.WriteHeader(http.StatusOK)
+}
+
+// SendDeviceList validates and appends a batch of devices in one call,
+// returning a per-item status report in the order they were submitted.
+func (se *ShellyExporter) SendDeviceList(w http.ResponseWriter, r *http.Request) {
+	var devices []Device
+	if err := json.NewDecoder(r.Body).Decode(&devices); err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding devices: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	se.observationMutex.Lock()
+	defer se.observationMutex.Unlock()
+
+	results := make([]Result, len(devices))
+	for i, device := range devices {
+		if err := se.validateDevice(device); err != nil {
+			results[i] = Result{Instance: device.Instance, Status: "invalid", Error: err.Error()}
+			continue
+		}
+
+		before := se.devices.len()
+		se.AddDevice(device)
+		status := "added"
+		if se.devices.len() == before {
+			status = "duplicate"
+		}
+		results[i] = Result{Instance: device.Instance, Status: status}
+	}
+
+	jsonMsg, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error marshalling results: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonMsg)
+}
+
+// DeleteDeviceList removes every device whose instance name is in the
+// submitted list, returning a per-item status report.
+func (se *ShellyExporter) DeleteDeviceList(w http.ResponseWriter, r *http.Request) {
+	var instances []string
+	if err := json.NewDecoder(r.Body).Decode(&instances); err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding instances: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	se.observationMutex.Lock()
+	defer se.observationMutex.Unlock()
+
+	results := make([]Result, 0, len(instances))
+	for _, instance := range instances {
+		if se.devices.remove(instance) {
+			delete(se.observations, instance)
+			delete(se.genericMetrics, instance)
+			se.publishEvent(Event{Type: EventDeviceRemoved, Instance: instance})
+			results = append(results, Result{Instance: instance, Status: "removed"})
+			continue
+		}
+		results = append(results, Result{Instance: instance, Status: "not_found"})
+	}
+
+	jsonMsg, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error marshalling results: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonMsg)
+}
+
+func (se *ShellyExporter) DeviceByInstance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		se.UpdateDevice(w, r)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		se.DeleteDevice(w, r)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (se *ShellyExporter) DevicesBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		se.SendDeviceList(w, r)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		se.DeleteDeviceList(w, r)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
 func (se *ShellyExporter) Devices(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		se.DeviceList(w, r)
@@ -189,12 +364,15 @@ func (se *ShellyExporter) HealthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func (se *ShellyExporter) Serve() {
-	log.Infof("Starting metrics server at %s", se.MetricsEndpoint)
+	se.infof(nil, "Starting metrics server at %s", se.MetricsEndpoint)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/metrics", se.GetObservations)
 	mux.HandleFunc("/health", se.HealthCheck)
 	mux.HandleFunc("/devices", se.Devices)
-	mux.HandleFunc("/devices/{instance}", se.UpdateDevice)
+	mux.HandleFunc("/devices/bulk", se.DevicesBulk)
+	mux.HandleFunc("/devices/{instance}", se.DeviceByInstance)
+	mux.HandleFunc("/subscriptions", se.Subscriptions)
+	mux.HandleFunc("/subscriptions/{id}", se.SubscriptionByID)
 	se.srv = &http.Server{
 		Addr:              se.MetricsEndpoint,
 		Handler:           mux,
@@ -202,14 +380,18 @@ func (se *ShellyExporter) Serve() {
 	}
 
 	if err := se.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Could not listen on %s: %v\n", se.MetricsEndpoint, err)
+		se.errorf(nil, "Could not listen on %s: %v", se.MetricsEndpoint, err)
+		os.Exit(1)
 	}
 
 }
 
 func (se *ShellyExporter) Start() {
 	se.ticker = time.NewTicker(se.SamplingFreq)
+	se.tickStop = make(chan struct{})
+	se.tickWg.Add(1)
 	go se.tick()
+	go se.dispatchEvents()
 
 	se.Serve()
 }
@@ -217,10 +399,23 @@ func (se *ShellyExporter) Start() {
 func (se *ShellyExporter) Stop() {
 	if se.ticker != nil {
 		se.ticker.Stop()
+		close(se.tickStop)
+		se.tickWg.Wait()
 	}
 	if se.srv != nil {
 		se.srv.Shutdown(context.Background())
 	}
+	if se.mqttPublisher != nil {
+		se.mqttPublisher.Close()
+	}
+	if se.kafkaProducer != nil {
+		if err := se.kafkaProducer.Close(); err != nil {
+			se.errorf(nil, "Error closing kafka producer: %v", err)
+		}
+	}
+	if se.events != nil {
+		close(se.events)
+	}
 }
 
 func (se *ShellyExporter) getPowerState(device Device) (PowerStateResponse, error) {
@@ -241,3 +436,37 @@ func (se *ShellyExporter) getPowerState(device Device) (PowerStateResponse, erro
 
 	return result, nil
 }
+
+// setPowerState calls the device's Switch.Set RPC to turn it on or off,
+// mirroring the read side implemented by getPowerState.
+func (se *ShellyExporter) setPowerState(device Device, on bool) error {
+	url := fmt.Sprintf("http://%s:%d/rpc/Switch.Set?id=0&on=%t", device.IP, device.Port, on)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(nil))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return nil
+}
+
+// subscribeMQTTCommands registers a command handler for device on the mqtt
+// publisher's "set" topic, if mqtt publishing is enabled.
+func (se *ShellyExporter) subscribeMQTTCommands(device Device) {
+	if se.mqttPublisher == nil {
+		return
+	}
+	err := se.mqttPublisher.SubscribeCommands(device.Instance, func(instance string, on bool) error {
+		if err := se.setPowerState(device, on); err != nil {
+			se.errorf(instanceFields(instance), "Error setting power state for device %s: %v", instance, err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		se.errorf(instanceFields(device.Instance), "Error subscribing to mqtt commands for device %s: %v", device.Instance, err)
+	}
+}
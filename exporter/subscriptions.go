@@ -0,0 +1,286 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Event types a subscriber can register interest in.
+const (
+	EventDeviceDiscovered      = "DeviceDiscovered"
+	EventDeviceRemoved         = "DeviceRemoved"
+	EventPowerThresholdCrossed = "PowerThresholdCrossed"
+	EventDeviceUnreachable     = "DeviceUnreachable"
+)
+
+// deviceUnreachableThreshold is how many consecutive getPowerState failures
+// trigger a DeviceUnreachable event.
+const deviceUnreachableThreshold = 3
+
+// subscriptionEventQueueSize bounds the dispatcher's event channel so a
+// burst of events (or a slow subscriber) cannot block the sampling loop.
+const subscriptionEventQueueSize = 256
+
+// Subscription is a webhook registration for push notifications about
+// device lifecycle and power events.
+type Subscription struct {
+	ID             string   `json:"id"`
+	Destination    string   `json:"destination"`
+	EventTypes     []string `json:"event_types"`
+	InstanceFilter string   `json:"instance_filter,omitempty"`
+	ThresholdWatts float64  `json:"threshold_watts,omitempty"`
+}
+
+// Event is delivered to matching subscriptions as an HTTP POST body.
+type Event struct {
+	Type       string    `json:"type"`
+	Instance   string    `json:"instance"`
+	Timestamp  time.Time `json:"timestamp"`
+	APower     float64   `json:"apower,omitempty"`
+	PrevAPower float64   `json:"prev_apower,omitempty"`
+}
+
+// matches reports whether event should be delivered to sub.
+func (sub Subscription) matches(event Event) bool {
+	typeMatches := false
+	for _, t := range sub.EventTypes {
+		if t == event.Type {
+			typeMatches = true
+			break
+		}
+	}
+	if !typeMatches {
+		return false
+	}
+
+	if sub.InstanceFilter != "" && sub.InstanceFilter != event.Instance {
+		return false
+	}
+
+	if event.Type == EventPowerThresholdCrossed {
+		crossed := (event.PrevAPower < sub.ThresholdWatts) != (event.APower < sub.ThresholdWatts)
+		if !crossed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// loadSubscriptions reads the persisted subscription list from path. A
+// missing file is treated as an empty list.
+func loadSubscriptions(path string) ([]Subscription, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []Subscription{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// saveSubscriptions persists the subscription list to se.subscriptionListPath.
+func (se *ShellyExporter) saveSubscriptions() {
+	if se.subscriptionListPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(se.subscriptions, "", "  ")
+	if err != nil {
+		se.errorf(nil, "Error marshalling subscriptions: %v", err)
+		return
+	}
+	if err := os.WriteFile(se.subscriptionListPath, data, 0o644); err != nil {
+		se.errorf(nil, "Error writing subscription list to %s: %v", se.subscriptionListPath, err)
+	}
+}
+
+// dispatchEvents drains se.events and delivers each one to matching
+// subscriptions, one goroutine per delivery so a slow subscriber can't
+// delay the next event.
+func (se *ShellyExporter) dispatchEvents() {
+	for event := range se.events {
+		se.subscriptionMutex.RLock()
+		subs := make([]Subscription, len(se.subscriptions))
+		copy(subs, se.subscriptions)
+		se.subscriptionMutex.RUnlock()
+
+		for _, sub := range subs {
+			if !sub.matches(event) {
+				continue
+			}
+			go se.deliverEvent(sub, event)
+		}
+	}
+}
+
+// deliverEvent POSTs event to sub.Destination, retrying with exponential
+// backoff on failure.
+func (se *ShellyExporter) deliverEvent(sub Subscription, event Event) {
+	fields := Fields{"instance": event.Instance, "subscription": sub.ID}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		se.errorf(fields, "Error marshalling event for subscription %s: %v", sub.ID, err)
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	const maxAttempts = 3
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		resp, err := http.Post(sub.Destination, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+
+		se.warnf(fields, "Attempt %d/%d delivering %s to subscription %s failed: %v", attempt, maxAttempts, event.Type, sub.ID, err)
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	se.errorf(fields, "Giving up delivering %s to subscription %s after %d attempts", event.Type, sub.ID, maxAttempts)
+}
+
+// publishEvent enqueues event for dispatch, dropping it if the dispatcher is
+// falling behind rather than blocking the sampling loop.
+func (se *ShellyExporter) publishEvent(event Event) {
+	event.Timestamp = time.Now().UTC()
+	select {
+	case se.events <- event:
+	default:
+		se.warnf(instanceFields(event.Instance), "Dropping %s event for %s, subscription dispatch queue is full", event.Type, event.Instance)
+	}
+}
+
+func (se *ShellyExporter) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	se.subscriptionMutex.RLock()
+	defer se.subscriptionMutex.RUnlock()
+
+	jsonMsg, err := json.MarshalIndent(se.subscriptions, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error marshalling subscriptions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonMsg)
+}
+
+func (se *ShellyExporter) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var sub Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding subscription: %v", err), http.StatusBadRequest)
+		return
+	}
+	if sub.Destination == "" || len(sub.EventTypes) == 0 {
+		http.Error(w, "destination and event_types are required", http.StatusBadRequest)
+		return
+	}
+
+	se.subscriptionMutex.Lock()
+	se.nextSubscriptionID++
+	sub.ID = fmt.Sprintf("sub-%d", se.nextSubscriptionID)
+	se.subscriptions = append(se.subscriptions, sub)
+	se.saveSubscriptions()
+	se.subscriptionMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (se *ShellyExporter) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	se.subscriptionMutex.Lock()
+	defer se.subscriptionMutex.Unlock()
+
+	for i, sub := range se.subscriptions {
+		if sub.ID == id {
+			se.subscriptions = append(se.subscriptions[:i], se.subscriptions[i+1:]...)
+			se.saveSubscriptions()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	http.Error(w, "Subscription not found", http.StatusNotFound)
+}
+
+func (se *ShellyExporter) SubscriptionByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		se.DeleteSubscription(w, r)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (se *ShellyExporter) Subscriptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		se.ListSubscriptions(w, r)
+		return
+	}
+	if r.Method == http.MethodPost {
+		se.CreateSubscription(w, r)
+		return
+	}
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// recordFailure increments device's consecutive failure count and emits a
+// DeviceUnreachable event the moment it crosses deviceUnreachableThreshold.
+// A success resets the count.
+func (se *ShellyExporter) recordFailure(instance string, failed bool) {
+	se.subscriptionMutex.Lock()
+	if !failed {
+		se.consecutiveFailures[instance] = 0
+		se.subscriptionMutex.Unlock()
+		return
+	}
+	se.consecutiveFailures[instance]++
+	count := se.consecutiveFailures[instance]
+	se.subscriptionMutex.Unlock()
+
+	if count == deviceUnreachableThreshold {
+		se.publishEvent(Event{Type: EventDeviceUnreachable, Instance: instance})
+	}
+}
+
+// checkPowerThreshold records the instance's current APower and, if this is
+// an update, emits a PowerThresholdCrossed event for the dispatcher to
+// evaluate against each subscription's own threshold.
+func (se *ShellyExporter) checkPowerThreshold(instance string, current float64) {
+	se.subscriptionMutex.Lock()
+	prev, seen := se.lastAPower[instance]
+	se.lastAPower[instance] = current
+	se.subscriptionMutex.Unlock()
+
+	if !seen {
+		return
+	}
+	se.publishEvent(Event{Type: EventPowerThresholdCrossed, Instance: instance, APower: current, PrevAPower: prev})
+}
+
+// defaultSubscriptionListPath derives a subscription list file path from the
+// exporter's config dir when the operator hasn't set one explicitly.
+func defaultSubscriptionListPath(configPath string) string {
+	dir := "."
+	if idx := strings.LastIndex(configPath, "/"); idx != -1 {
+		dir = configPath[:idx]
+	}
+	return dir + "/subscriptions.json"
+}
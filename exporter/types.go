@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"github.com/grandcat/zeroconf"
+
+	"github.com/ISE-TU-Berlin/shelly-exporter/exporter/kafka"
+	"github.com/ISE-TU-Berlin/shelly-exporter/exporter/mqtt"
 )
 
 type Device struct {
@@ -13,6 +16,11 @@ type Device struct {
 	Instance string `yaml:"instance"`
 	IP       string `yaml:"ip"`
 	Port     int    `yaml:"port"`
+
+	// Endpoints lists the Gen2/Gen3 RPC endpoints (e.g. "Sys.GetStatus",
+	// "WiFi.GetStatus", "Shelly.GetStatus", "Temperature.GetStatus") to
+	// poll for this device. Defaults to just "Switch.GetStatus" when unset.
+	Endpoints []string `yaml:"endpoints"`
 }
 
 type DeviceSet struct {
@@ -37,8 +45,28 @@ func (ds *DeviceSet) getAll() []Device {
 	return ds.Devices
 }
 
+// remove deletes the device with the given instance name, reporting whether
+// it was found.
+func (ds *DeviceSet) remove(instance string) bool {
+	for i, d := range ds.Devices {
+		if d.Instance == instance {
+			ds.Devices = append(ds.Devices[:i], ds.Devices[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Result reports the outcome of a single device in a bulk operation.
+type Result struct {
+	Instance string `json:"instance"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
 type PowerStateResponse struct {
 	Instance  string    `json:"instance"`
+	Name      string    `json:"name"`
 	Timestamp time.Time `json:"timestamp"`
 	APower    float64   `json:"apower"`
 	Voltage   float64   `json:"voltage"`
@@ -57,10 +85,27 @@ type ShellyExporter struct {
 	devices  DeviceSet
 
 	ticker           *time.Ticker
+	tickStop         chan struct{}
+	tickWg           sync.WaitGroup
 	observations     map[string]*PowerStateResponse
+	genericMetrics   map[string]*genericReading
 	observationMutex sync.RWMutex
 
 	srv *http.Server
+
+	mqttPublisher *mqtt.Publisher
+	kafkaProducer *kafka.Producer
+
+	// subscriptions backs the webhook subscription service.
+	subscriptions        []Subscription
+	subscriptionMutex    sync.RWMutex
+	subscriptionListPath string
+	nextSubscriptionID   int
+	events               chan Event
+	consecutiveFailures  map[string]int
+	lastAPower           map[string]float64
+
+	logger Logger
 }
 
 // DeviceCount returns the number of known devices